@@ -8,20 +8,23 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/charmbracelet/log"
-
+	"ts_trade_ws/internal/logging"
 	"ts_trade_ws/internal/server"
 )
 
 func main() {
+	log := logging.New()
+
 	ctx, cancel := context.WithCancel(context.Background())
-	srv, err := server.New(ctx)
+	srv, err := server.New(ctx, log)
 	if err != nil {
-		log.Fatal("failed to start server", "err", err)
+		log.Error("failed to start server", "err", err)
+		os.Exit(1)
 	}
 
 	http.HandleFunc("/ws", srv.HandleWS)
 	http.HandleFunc("/recent", srv.HandleRecent)
+	http.HandleFunc("/hub/metrics", srv.HandleHubMetrics)
 
 	port := os.Getenv("API_PORT")
 	if port == "" {
@@ -36,7 +39,8 @@ func main() {
 	go func() {
 		log.Info("websocket API listening", "port", port)
 		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("http server error", "err", err)
+			log.Error("http server error", "err", err)
+			os.Exit(1)
 		}
 	}()
 
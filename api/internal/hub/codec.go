@@ -0,0 +1,70 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Subprotocol identifiers negotiated via the Sec-WebSocket-Protocol header.
+// A client that doesn't request one of these (or an older client that
+// doesn't send the header at all) falls back to JSON.
+const (
+	ProtocolJSON    = "predictions.json.v1"
+	ProtocolMsgpack = "predictions.msgpack.v1"
+	ProtocolCBOR    = "predictions.cbor.v1"
+)
+
+// Codec encodes a value into wire bytes plus the websocket frame type
+// (websocket.TextMessage or websocket.BinaryMessage) it must be sent as.
+type Codec interface {
+	Encode(v any) ([]byte, int, error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v any) ([]byte, int, error) {
+	b, err := json.Marshal(v)
+	return b, websocket.TextMessage, err
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(v any) ([]byte, int, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json") // reuse the existing json field names on the wire
+	if err := enc.Encode(v); err != nil {
+		return nil, websocket.BinaryMessage, err
+	}
+	return buf.Bytes(), websocket.BinaryMessage, nil
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Encode(v any) ([]byte, int, error) {
+	b, err := cbor.Marshal(v)
+	return b, websocket.BinaryMessage, err
+}
+
+var codecsByProtocol = map[string]Codec{
+	ProtocolJSON:    jsonCodec{},
+	ProtocolMsgpack: msgpackCodec{},
+	ProtocolCBOR:    cborCodec{},
+}
+
+// Subprotocols lists every subprotocol the server supports, in priority
+// order, for use as the upgrader's Subprotocols list.
+var Subprotocols = []string{ProtocolJSON, ProtocolMsgpack, ProtocolCBOR}
+
+// CodecFor returns the Codec for a negotiated subprotocol (conn.Subprotocol()
+// after upgrade), defaulting to JSON for "" or anything unrecognized.
+func CodecFor(protocol string) Codec {
+	if c, ok := codecsByProtocol[protocol]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
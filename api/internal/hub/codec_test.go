@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type codecTestPayload struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+}
+
+func TestCodecForDefaultsToJSON(t *testing.T) {
+	cases := []string{"", "unknown-protocol"}
+	for _, protocol := range cases {
+		if _, ok := CodecFor(protocol).(jsonCodec); !ok {
+			t.Errorf("CodecFor(%q) did not default to jsonCodec", protocol)
+		}
+	}
+}
+
+func TestCodecForKnownProtocols(t *testing.T) {
+	cases := []struct {
+		protocol string
+		want     Codec
+	}{
+		{ProtocolJSON, jsonCodec{}},
+		{ProtocolMsgpack, msgpackCodec{}},
+		{ProtocolCBOR, cborCodec{}},
+	}
+	for _, tc := range cases {
+		got := CodecFor(tc.protocol)
+		if got != tc.want {
+			t.Errorf("CodecFor(%q) = %#v, want %#v", tc.protocol, got, tc.want)
+		}
+	}
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	want := codecTestPayload{Symbol: "AAPL", Price: 123.45}
+	b, msgType, err := jsonCodec{}.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if msgType != websocket.TextMessage {
+		t.Errorf("msgType = %d, want TextMessage", msgType)
+	}
+	var got codecTestPayload
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestMsgpackCodecRoundTrip(t *testing.T) {
+	want := codecTestPayload{Symbol: "MSFT", Price: 67.89}
+	b, msgType, err := msgpackCodec{}.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if msgType != websocket.BinaryMessage {
+		t.Errorf("msgType = %d, want BinaryMessage", msgType)
+	}
+	var got codecTestPayload
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestCBORCodecRoundTrip(t *testing.T) {
+	want := codecTestPayload{Symbol: "GOOG", Price: 321.0}
+	b, msgType, err := cborCodec{}.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if msgType != websocket.BinaryMessage {
+		t.Errorf("msgType = %d, want BinaryMessage", msgType)
+	}
+	var got codecTestPayload
+	if err := cbor.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
@@ -2,32 +2,112 @@ package hub
 
 import (
 	"context"
+	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// wildcardSymbol subscribes a client to every broadcast, regardless of the
+// message's symbol. Newly registered clients start on the wildcard so the
+// default behavior (receive everything) is unchanged until they subscribe.
+const wildcardSymbol = "*"
+
+// ringBufferSize bounds how many recent broadcasts TryJoin can replay to bridge
+// the gap between a caller's backfill query and the moment it starts
+// receiving live broadcasts.
+const ringBufferSize = 500
+
+// maxConnsPerIP caps concurrent connections from a single client IP, so one
+// misbehaving or compromised client can't exhaust server resources.
+// Configurable via HUB_MAX_CONNS_PER_IP; 0 (the default) means unlimited.
+var maxConnsPerIP = 0
+
+func init() {
+	if v, ok := os.LookupEnv("HUB_MAX_CONNS_PER_IP"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxConnsPerIP = n
+		}
+	}
+}
+
+// Message is a routed payload broadcast to interested clients. Symbol drives
+// routing through the Hub's subscriber index; Data is the value each
+// client's negotiated Codec encodes independently, so the same broadcast
+// can reach one client as JSON and another as MessagePack or CBOR.
 type Message struct {
-	Payload string
+	Symbol    string
+	Data      any
+	Timestamp time.Time
 }
 
 type Client interface {
 	Send(msg Message)
 	Close()
+	// QueueDepth reports how many outbound frames are currently buffered,
+	// for the metrics endpoint.
+	QueueDepth() int
+}
+
+// ClientMetrics is a point-in-time snapshot of one client's send queue.
+type ClientMetrics struct {
+	QueueDepth int `json:"queueDepth"`
+}
+
+// HubMetrics aggregates backpressure counters across all clients, exposed
+// via the server's metrics endpoint so operators can see slow consumers.
+type HubMetrics struct {
+	DroppedMessages uint64          `json:"droppedMessages"`
+	Evictions       uint64          `json:"evictions"`
+	Clients         []ClientMetrics `json:"clients"`
 }
 
 type Hub struct {
-	clients    map[Client]struct{}
-	register   chan Client
-	unregister chan Client
-	broadcast  chan Message
-	mu         sync.RWMutex
+	clients     map[Client]struct{}
+	clientIPs   map[Client]string // client -> IP it registered from, for maxConnsPerIP bookkeeping
+	ipCounts    map[string]int
+	subscribers map[string]map[Client]struct{} // symbol -> subscribed clients, wildcardSymbol -> all
+	unregister  chan Client
+	subscribe   chan subscription
+	unsubscribe chan subscription
+	resubscribe chan subscriptionChange
+	broadcast   chan Message
+	mu          sync.RWMutex
+
+	ring []Message
+
+	dropped   uint64
+	evictions uint64
+}
+
+type subscription struct {
+	client  Client
+	symbols []string
+}
+
+// subscriptionChange swaps a client's routing entries in one hub operation:
+// every symbol in remove is dropped and every symbol in add is added, both
+// under the same h.mu acquisition, so Hub.run() can never process a
+// broadcast in the gap between the two (e.g. while a client is narrowing
+// from the wildcard to specific symbols).
+type subscriptionChange struct {
+	client Client
+	remove []string
+	add    []string
 }
 
 func New() *Hub {
 	h := &Hub{
-		clients:    make(map[Client]struct{}),
-		register:   make(chan Client),
-		unregister: make(chan Client),
-		broadcast:  make(chan Message, 32),
+		clients:     make(map[Client]struct{}),
+		clientIPs:   make(map[Client]string),
+		ipCounts:    make(map[string]int),
+		subscribers: make(map[string]map[Client]struct{}),
+		unregister:  make(chan Client),
+		subscribe:   make(chan subscription),
+		unsubscribe: make(chan subscription),
+		resubscribe: make(chan subscriptionChange),
+		broadcast:   make(chan Message, 32),
 	}
 	go h.run()
 	return h
@@ -36,43 +116,236 @@ func New() *Hub {
 func (h *Hub) run() {
 	for {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = struct{}{}
-			h.mu.Unlock()
 		case client := <-h.unregister:
 			h.removeClient(client)
+		case sub := <-h.subscribe:
+			h.mu.Lock()
+			for _, symbol := range sub.symbols {
+				h.addToIndex(sub.client, symbol)
+			}
+			h.mu.Unlock()
+		case sub := <-h.unsubscribe:
+			h.mu.Lock()
+			for _, symbol := range sub.symbols {
+				h.removeFromIndex(sub.client, symbol)
+			}
+			h.mu.Unlock()
+		case change := <-h.resubscribe:
+			h.mu.Lock()
+			for _, symbol := range change.remove {
+				h.removeFromIndex(change.client, symbol)
+			}
+			for _, symbol := range change.add {
+				h.addToIndex(change.client, symbol)
+			}
+			h.mu.Unlock()
 		case msg := <-h.broadcast:
-			h.mu.RLock()
-			for c := range h.clients {
+			h.mu.Lock()
+			h.pushRing(msg)
+			delivered := make(map[Client]struct{})
+			for c := range h.subscribers[wildcardSymbol] {
 				c.Send(msg)
+				delivered[c] = struct{}{}
+			}
+			if msg.Symbol != "" && msg.Symbol != wildcardSymbol {
+				for c := range h.subscribers[msg.Symbol] {
+					if _, ok := delivered[c]; ok {
+						continue
+					}
+					c.Send(msg)
+				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
 
+// pushRing assumes the caller holds h.mu.
+func (h *Hub) pushRing(msg Message) {
+	h.ring = append(h.ring, msg)
+	if len(h.ring) > ringBufferSize {
+		h.ring = h.ring[len(h.ring)-ringBufferSize:]
+	}
+}
+
+// addToIndex and removeFromIndex assume the caller holds h.mu.
+func (h *Hub) addToIndex(client Client, symbol string) {
+	set, ok := h.subscribers[symbol]
+	if !ok {
+		set = make(map[Client]struct{})
+		h.subscribers[symbol] = set
+	}
+	set[client] = struct{}{}
+}
+
+func (h *Hub) removeFromIndex(client Client, symbol string) {
+	set, ok := h.subscribers[symbol]
+	if !ok {
+		return
+	}
+	delete(set, client)
+	if len(set) == 0 {
+		delete(h.subscribers, symbol)
+	}
+}
+
 func (h *Hub) removeClient(client Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if _, ok := h.clients[client]; ok {
 		delete(h.clients, client)
+		for symbol, set := range h.subscribers {
+			delete(set, client)
+			if len(set) == 0 {
+				delete(h.subscribers, symbol)
+			}
+		}
+		h.releaseIP(client)
 		client.Close()
 	}
 }
 
-func (h *Hub) Broadcast(payload string) {
-	h.broadcast <- Message{Payload: payload}
+// addClientLocked registers client for live broadcasts under h.mu, assumed
+// already held, bumping ip's connection count if ip is non-empty.
+func (h *Hub) addClientLocked(client Client, ip string) {
+	h.clients[client] = struct{}{}
+	h.addToIndex(client, wildcardSymbol)
+	if ip != "" {
+		h.clientIPs[client] = ip
+		h.ipCounts[ip]++
+	}
+}
+
+// ipLimitExceededLocked reports whether ip is already at maxConnsPerIP.
+// Assumes h.mu is held.
+func (h *Hub) ipLimitExceededLocked(ip string) bool {
+	return maxConnsPerIP > 0 && ip != "" && h.ipCounts[ip] >= maxConnsPerIP
+}
+
+// releaseIP assumes h.mu is held and decrements ip's connection count,
+// dropping the entry once it reaches zero.
+func (h *Hub) releaseIP(client Client) {
+	ip, ok := h.clientIPs[client]
+	if !ok {
+		return
+	}
+	delete(h.clientIPs, client)
+	h.ipCounts[ip]--
+	if h.ipCounts[ip] <= 0 {
+		delete(h.ipCounts, ip)
+	}
+}
+
+// Broadcast routes msg to every client subscribed to msg.Symbol plus every
+// client subscribed to the wildcard.
+func (h *Hub) Broadcast(msg Message) {
+	h.broadcast <- msg
+}
+
+// Subscribe adds symbols to client's routing entries. Pass wildcardSymbol
+// ("*") to receive every broadcast.
+func (h *Hub) Subscribe(client Client, symbols []string) {
+	if len(symbols) == 0 {
+		return
+	}
+	h.subscribe <- subscription{client: client, symbols: symbols}
+}
+
+// Unsubscribe removes symbols from client's routing entries.
+func (h *Hub) Unsubscribe(client Client, symbols []string) {
+	if len(symbols) == 0 {
+		return
+	}
+	h.unsubscribe <- subscription{client: client, symbols: symbols}
+}
+
+// Resubscribe atomically drops remove and adds add to client's routing
+// entries in a single hub operation. Use this instead of a separate
+// Unsubscribe+Subscribe pair when swapping a client's symbol set (e.g.
+// narrowing from the wildcard), since two sends on separate channels leave
+// a window, between Hub.run() processing them, where a queued broadcast can
+// land while the client is registered in neither set.
+func (h *Hub) Resubscribe(client Client, remove, add []string) {
+	if len(remove) == 0 && len(add) == 0 {
+		return
+	}
+	h.resubscribe <- subscriptionChange{client: client, remove: remove, add: add}
 }
 
-func (h *Hub) Register(client Client) {
-	h.register <- client
+// AtIPLimit reports whether ip is already at the configured per-IP
+// connection limit (HUB_MAX_CONNS_PER_IP), so callers can reject a request
+// with HTTP 429 before paying the cost of upgrading it to a websocket.
+func (h *Hub) AtIPLimit(ip string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ipLimitExceededLocked(ip)
+}
+
+// TryRegister registers client for live broadcasts from ip, unless ip is
+// already at the configured per-IP connection limit (HUB_MAX_CONNS_PER_IP),
+// in which case it returns false without registering the client.
+func (h *Hub) TryRegister(client Client, ip string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ipLimitExceededLocked(ip) {
+		return false
+	}
+	h.addClientLocked(client, ip)
+	return true
 }
 
 func (h *Hub) Unregister(client Client) {
 	h.unregister <- client
 }
 
+// TryJoin registers client for live broadcasts from ip and, atomically with
+// that registration, returns any buffered broadcasts newer than since. It
+// returns false without registering if ip is already at the configured
+// per-IP connection limit. Callers that backfill from storage before going
+// live should query first, then call TryJoin(client, ip, cutoff) with a
+// cutoff taken after the backfill query returns, so a broadcast sent while
+// the query was running is not delivered by both the backfill and the
+// bridge. Only broadcasts timestamped after cutoff are bridged.
+func (h *Hub) TryJoin(client Client, ip string, since time.Time) ([]Message, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ipLimitExceededLocked(ip) {
+		return nil, false
+	}
+	h.addClientLocked(client, ip)
+	var bridge []Message
+	for _, m := range h.ring {
+		if m.Timestamp.After(since) {
+			bridge = append(bridge, m)
+		}
+	}
+	return bridge, true
+}
+
+func (h *Hub) recordDropped() {
+	atomic.AddUint64(&h.dropped, 1)
+}
+
+func (h *Hub) recordEviction() {
+	atomic.AddUint64(&h.evictions, 1)
+}
+
+// Metrics returns a snapshot of backpressure counters plus each connected
+// client's current send queue depth.
+func (h *Hub) Metrics() HubMetrics {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]ClientMetrics, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, ClientMetrics{QueueDepth: c.QueueDepth()})
+	}
+	return HubMetrics{
+		DroppedMessages: atomic.LoadUint64(&h.dropped),
+		Evictions:       atomic.LoadUint64(&h.evictions),
+		Clients:         clients,
+	}
+}
+
 func (h *Hub) Shutdown(ctx context.Context) {
 	done := make(chan struct{})
 	go func() {
@@ -81,6 +354,9 @@ func (h *Hub) Shutdown(ctx context.Context) {
 			c.Close()
 			delete(h.clients, c)
 		}
+		h.subscribers = make(map[string]map[Client]struct{})
+		h.clientIPs = make(map[Client]string)
+		h.ipCounts = make(map[string]int)
 		h.mu.Unlock()
 		close(done)
 	}()
@@ -0,0 +1,203 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// noopClient discards everything sent to it; used where a test only cares
+// about the hub's own bookkeeping (ring buffer, subscriber index), not what
+// a specific client does with a delivered message.
+type noopClient struct{}
+
+func (noopClient) Send(Message)    {}
+func (noopClient) Close()          {}
+func (noopClient) QueueDepth() int { return 0 }
+
+// evictingClient simulates a WSClient whose Send, on some call, reacts to
+// backpressure by unregistering itself from the hub — the same shape as
+// WSClient.enqueue's evict policy. async controls whether that
+// call is synchronous (the bug) or asynchronous (the fix), so the same test
+// can demonstrate the deadlock and confirm the fix avoids it.
+type evictingClient struct {
+	hub      *Hub
+	async    bool
+	sendDone chan struct{}
+	once     sync.Once
+}
+
+func (c *evictingClient) Send(Message) {
+	if c.async {
+		go c.hub.Unregister(c)
+	} else {
+		c.hub.Unregister(c)
+	}
+	c.once.Do(func() { close(c.sendDone) })
+}
+
+func (c *evictingClient) Close()          {}
+func (c *evictingClient) QueueDepth() int { return 0 }
+
+// TestBroadcastSurvivesUnregisterDuringSend reproduces the chunk0-3
+// self-deadlock: Hub.run() is holding h.mu inside the broadcast case when a
+// client's Send synchronously calls Unregister, which blocks forever on the
+// unbuffered h.unregister channel because run() isn't back at its select
+// loop to receive it. The fix (async Unregister) must let Broadcast, and the
+// hub as a whole, keep making progress afterward.
+func TestBroadcastSurvivesUnregisterDuringSend(t *testing.T) {
+	h := New()
+	client := &evictingClient{hub: h, async: true, sendDone: make(chan struct{})}
+	h.TryRegister(client, "")
+
+	h.Broadcast(Message{Symbol: wildcardSymbol})
+
+	select {
+	case <-client.sendDone:
+	case <-time.After(time.Second):
+		t.Fatal("client.Send was never invoked")
+	}
+
+	// If Unregister's channel send blocked run(), the hub would already be
+	// wedged and this second Broadcast would never be accepted.
+	done := make(chan struct{})
+	go func() {
+		h.Broadcast(Message{Symbol: wildcardSymbol})
+		h.Metrics()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hub deadlocked after client unregistered itself from inside Send")
+	}
+}
+
+// signalingClient closes done the instant a Send sent matches a sentinel
+// symbol, letting a test block until the hub has finished processing every
+// broadcast up to and including that one — pushRing happens under h.mu
+// before any subscriber's Send is called, so observing the sentinel
+// guarantees the ring buffer already reflects it.
+type signalingClient struct {
+	sentinel string
+	done     chan struct{}
+}
+
+func (c *signalingClient) Send(msg Message) {
+	if msg.Symbol == c.sentinel {
+		close(c.done)
+	}
+}
+func (c *signalingClient) Close()          {}
+func (c *signalingClient) QueueDepth() int { return 0 }
+
+func TestTryJoinBridgesOnlyMessagesAfterSince(t *testing.T) {
+	h := New()
+	sentinel := &signalingClient{sentinel: "LAST", done: make(chan struct{})}
+	h.TryRegister(sentinel, "")
+
+	before := time.Now().UTC()
+	h.Broadcast(Message{Symbol: "OLD", Timestamp: before.Add(-time.Minute)})
+
+	since := time.Now().UTC()
+	h.Broadcast(Message{Symbol: "NEW", Timestamp: since.Add(time.Millisecond)})
+	h.Broadcast(Message{Symbol: "LAST", Timestamp: since.Add(2 * time.Millisecond)})
+
+	select {
+	case <-sentinel.done:
+	case <-time.After(time.Second):
+		t.Fatal("sentinel broadcast was never delivered")
+	}
+
+	joiner := noopClient{}
+	bridge, ok := h.TryJoin(joiner, "", since)
+	if !ok {
+		t.Fatal("TryJoin returned false")
+	}
+	var symbols []string
+	for _, m := range bridge {
+		symbols = append(symbols, m.Symbol)
+	}
+	if len(symbols) != 2 || symbols[0] != "NEW" || symbols[1] != "LAST" {
+		t.Errorf("bridge = %v, want [NEW LAST] (OLD predates since, so must be excluded)", symbols)
+	}
+}
+
+// recordingClient records every message Symbol delivered to it, for tests
+// that need to count deliveries rather than just detect one.
+type recordingClient struct {
+	mu      sync.Mutex
+	symbols []string
+}
+
+func (c *recordingClient) Send(msg Message) {
+	c.mu.Lock()
+	c.symbols = append(c.symbols, msg.Symbol)
+	c.mu.Unlock()
+}
+func (c *recordingClient) Close()          {}
+func (c *recordingClient) QueueDepth() int { return 0 }
+
+func (c *recordingClient) count(symbol string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, s := range c.symbols {
+		if s == symbol {
+			n++
+		}
+	}
+	return n
+}
+
+// TestBroadcastDedupesClientSubscribedToWildcardAndSymbol covers the
+// routing index's delivered-map dedup: a client in both the wildcard set
+// and a specific symbol's set must still only get Send called once per
+// matching broadcast, not once per matching set it belongs to.
+func TestBroadcastDedupesClientSubscribedToWildcardAndSymbol(t *testing.T) {
+	h := New()
+	client := &recordingClient{}
+	h.TryRegister(client, "") // subscribes to the wildcard
+	h.Subscribe(client, []string{"AAPL"})
+
+	sentinel := &signalingClient{sentinel: "BARRIER", done: make(chan struct{})}
+	h.TryRegister(sentinel, "")
+
+	h.Broadcast(Message{Symbol: "AAPL"})
+	h.Broadcast(Message{Symbol: "BARRIER"})
+
+	select {
+	case <-sentinel.done:
+	case <-time.After(time.Second):
+		t.Fatal("barrier broadcast was never delivered")
+	}
+
+	if got := client.count("AAPL"); got != 1 {
+		t.Errorf("client subscribed to both wildcard and AAPL received it %d times, want 1 (deduped)", got)
+	}
+}
+
+func TestPushRingTrimsToRingBufferSize(t *testing.T) {
+	h := New()
+	sentinel := &signalingClient{sentinel: "LAST", done: make(chan struct{})}
+	h.TryRegister(sentinel, "")
+
+	for i := 0; i < ringBufferSize+10; i++ {
+		h.Broadcast(Message{Symbol: "FILL", Timestamp: time.Now().UTC()})
+	}
+	h.Broadcast(Message{Symbol: "LAST", Timestamp: time.Now().UTC()})
+
+	select {
+	case <-sentinel.done:
+	case <-time.After(time.Second):
+		t.Fatal("sentinel broadcast was never delivered")
+	}
+
+	h.mu.RLock()
+	got := len(h.ring)
+	h.mu.RUnlock()
+	if got != ringBufferSize {
+		t.Errorf("len(ring) = %d, want capped at %d", got, ringBufferSize)
+	}
+}
@@ -8,13 +8,31 @@ import (
     "sync"
     "time"
 
-    "github.com/charmbracelet/log"
     "github.com/gorilla/websocket"
+
+    "ts_trade_ws/internal/logging"
 )
 
+// startupLog is used only for the package-level configuration messages
+// emitted from init, before any per-connection logger exists.
+var startupLog = logging.New()
+
 const (
     writeWait      = 10 * time.Second
     maxMessageSize = 64 * 1024
+
+    defaultSendQueueSize = 256
+
+    overflowPolicyDropOldest = "drop-oldest"
+    overflowPolicyEvict      = "evict"
+)
+
+// Configurable at runtime via env vars:
+//   WS_SEND_QUEUE_SIZE   - buffered frames per client before overflow kicks in
+//   WS_OVERFLOW_POLICY   - "drop-oldest" (default) or "evict"
+var (
+    sendQueueSize  = defaultSendQueueSize
+    overflowPolicy = overflowPolicyDropOldest
 )
 
 // Configurable at runtime via env vars:
@@ -27,6 +45,16 @@ var (
     pongWait   = 60 * time.Second
 )
 
+const defaultCompressionThreshold = 256
+
+// Configurable at runtime via env vars:
+//   WS_COMPRESSION_LEVEL           - flate level, -2..9 (default -1, i.e. flate.DefaultCompression)
+//   WS_COMPRESSION_THRESHOLD_BYTES - only compress frames at least this large (default 256)
+var (
+    compressionLevel     = -1
+    compressionThreshold = defaultCompressionThreshold
+)
+
 func init() {
     pingPeriod = envDuration("WS_PING_SECONDS", pingPeriod)
     pongWait = envDuration("WS_PONG_WAIT_SECONDS", pongWait)
@@ -34,7 +62,29 @@ func init() {
         // Ensure reasonable slack beyond ping cadence
         pongWait = pingPeriod * 2
     }
-    log.Info("ws heartbeat configured", "ping", pingPeriod.String(), "pongWait", pongWait.String())
+    startupLog.Info("ws heartbeat configured", "ping", pingPeriod.String(), "pongWait", pongWait.String())
+
+    if v, ok := os.LookupEnv("WS_SEND_QUEUE_SIZE"); ok {
+        if n, err := strconv.Atoi(v); err == nil && n > 0 {
+            sendQueueSize = n
+        }
+    }
+    if v := strings.TrimSpace(os.Getenv("WS_OVERFLOW_POLICY")); v == overflowPolicyEvict {
+        overflowPolicy = overflowPolicyEvict
+    }
+    startupLog.Info("ws send queue configured", "size", sendQueueSize, "overflowPolicy", overflowPolicy)
+
+    if v, ok := os.LookupEnv("WS_COMPRESSION_LEVEL"); ok {
+        if n, err := strconv.Atoi(v); err == nil {
+            compressionLevel = n
+        }
+    }
+    if v, ok := os.LookupEnv("WS_COMPRESSION_THRESHOLD_BYTES"); ok {
+        if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+            compressionThreshold = n
+        }
+    }
+    startupLog.Info("ws compression configured", "level", compressionLevel, "thresholdBytes", compressionThreshold)
 }
 
 func envDuration(key string, fallback time.Duration) time.Duration {
@@ -51,36 +101,266 @@ func envDuration(key string, fallback time.Duration) time.Duration {
     return fallback
 }
 
+// controlMessage is the inbound vocabulary clients use to manage their own
+// subscriptions and history: {"type":"subscribe","symbols":[...]},
+// {"type":"unsubscribe",...}, {"type":"subscriptions"} to query the current
+// set, and {"type":"resume","since":"<RFC3339>"} to backfill missed rows.
+type controlMessage struct {
+    Type    string   `json:"type"`
+    Symbols []string `json:"symbols"`
+    Since   string   `json:"since"`
+}
+
+// wsFrame is an already-encoded outbound message waiting in a client's send
+// queue, paired with the websocket frame type (Text or Binary) its codec
+// produced it as.
+type wsFrame struct {
+	data    []byte
+	msgType int
+}
+
 type WSClient struct {
 	conn      *websocket.Conn
+	hub       *Hub
+	remoteIP  string
+	codec     Codec
+	log       logging.Logger
 	mu        sync.Mutex
 	done      chan struct{}
 	closeOnce sync.Once
+
+	subMu sync.Mutex
+	subs  map[string]struct{}
+
+	writerOnce sync.Once
+
+	// resume, if set, is invoked when the client sends {"type":"resume"}
+	// over the wire. The server wires this to its own repository-backed
+	// backfill logic; the hub package stays storage-agnostic.
+	resume func(since string)
+
+	// outbound decouples Send (called from the hub's broadcast loop) from
+	// the actual write, so one slow client can't block fan-out to everyone
+	// else. writeLoop drains it and performs the queued writes.
+	outbound chan wsFrame
 }
 
-func NewWSClient(conn *websocket.Conn) *WSClient {
+// NewWSClient builds a client registered against h, starting subscribed to
+// the wildcard so it sees every broadcast until it subscribes to a subset.
+// remoteIP is the resolved client address (see internal/realip), not
+// necessarily conn's TCP peer, since that may be a reverse proxy. protocol
+// is the subprotocol gorilla negotiated during the handshake
+// (conn.Subprotocol()); it selects which Codec this client's outbound
+// messages are encoded with, defaulting to JSON. baseLog is stamped with
+// remoteIP and a fresh correlation ID so every log line for this
+// connection's lifetime — connect, ping, pong, broadcast delivery,
+// disconnect — can be traced back to the same client.
+func NewWSClient(conn *websocket.Conn, h *Hub, remoteIP, protocol string, baseLog logging.Logger) *WSClient {
+    _ = conn.SetCompressionLevel(compressionLevel)
+    codec := CodecFor(protocol)
     return &WSClient{
-        conn: conn,
-        done: make(chan struct{}),
+        conn:     conn,
+        hub:      h,
+        remoteIP: remoteIP,
+        codec:    codec,
+        log:      baseLog.With("conn_id", logging.NewCorrelationID(), "remote_ip", remoteIP, "protocol", protocolLabel(protocol)),
+        done:     make(chan struct{}),
+        subs:     map[string]struct{}{wildcardSymbol: {}},
+        outbound: make(chan wsFrame, sendQueueSize),
     }
 }
 
+// protocolLabel fills in the default protocol name for logging when the
+// client didn't negotiate one explicitly.
+func protocolLabel(protocol string) string {
+    if protocol == "" {
+        return ProtocolJSON
+    }
+    return protocol
+}
+
+// RemoteIP returns the resolved client IP this client was constructed with,
+// so the hub can enforce the per-IP connection limit.
+func (c *WSClient) RemoteIP() string {
+    return c.remoteIP
+}
+
+// Log returns this client's correlation-ID-scoped logger, so callers (e.g.
+// Server) can log their own connect/disconnect lines under the same ID.
+func (c *WSClient) Log() logging.Logger {
+    return c.log
+}
+
+// Send encodes msg.Data with this client's negotiated Codec and enqueues
+// the result for delivery without blocking. If the client's queue is full
+// it applies the configured overflow policy (drop-oldest or evict).
 func (c *WSClient) Send(msg Message) {
+	data, msgType, err := c.codec.Encode(msg.Data)
+	if err != nil {
+		c.log.Warn("failed to encode outbound message", "err", err)
+		return
+	}
+	c.enqueue(wsFrame{data: data, msgType: msgType})
+}
+
+func (c *WSClient) enqueue(f wsFrame) {
+	select {
+	case c.outbound <- f:
+		return
+	default:
+	}
+
+	if overflowPolicy == overflowPolicyEvict {
+		c.log.Warn("ws send queue full, evicting slow consumer", "policy", overflowPolicyEvict)
+		if c.hub != nil {
+			c.hub.recordEviction()
+			// Unregister sends on an unbuffered channel read only by
+			// Hub.run(), and enqueue can be called from inside run() itself
+			// (via Broadcast -> Client.Send). Unregistering synchronously
+			// here would deadlock run() against its own channel. Run it on
+			// its own goroutine so eviction never blocks the broadcast loop.
+			go c.hub.Unregister(c)
+		}
+		go c.Close()
+		return
+	}
+
+	c.log.Warn("ws send queue full, dropping oldest frame", "policy", overflowPolicyDropOldest)
+	select {
+	case <-c.outbound:
+	default:
+	}
+	select {
+	case c.outbound <- f:
+	default:
+		// Another writer drained/filled it between our drop and retry; give up on this frame.
+	}
+	if c.hub != nil {
+		c.hub.recordDropped()
+	}
+}
+
+// SetResumeHandler wires fn to be called when the client sends
+// {"type":"resume","since":"..."}. Must be called before Run.
+func (c *WSClient) SetResumeHandler(fn func(since string)) {
+    c.resume = fn
+}
+
+// QueueDepth reports how many frames are currently buffered for this client.
+func (c *WSClient) QueueDepth() int {
+	return len(c.outbound)
+}
+
+// StartWriter launches writeLoop if it hasn't already been started, so
+// callers that need to deliver frames (e.g. a resume backfill) before the
+// connection's full Run loop begins aren't writing into an outbound queue
+// nobody is draining. Safe to call more than once; Run calls it too, so an
+// early caller and Run never race to start a second writeLoop.
+func (c *WSClient) StartWriter() {
+	c.writerOnce.Do(func() {
+		go c.writeLoop()
+	})
+}
+
+func (c *WSClient) writeLoop() {
+	for {
+		select {
+		case f := <-c.outbound:
+			if c.writeFrame(f.data, f.msgType) {
+				c.log.Debug("broadcast delivered", "bytes", len(f.data))
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeFrame writes b as a single frame of the given type (Text or Binary),
+// reporting whether it succeeded. Frames at least compressionThreshold
+// bytes are sent with permessage-deflate compression, when negotiated.
+func (c *WSClient) writeFrame(b []byte, msgType int) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.conn == nil {
-		return
+		return false
 	}
 
+	c.conn.EnableWriteCompression(len(b) >= compressionThreshold)
+
 	if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 		go c.Close()
-		return
+		return false
 	}
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
+	if err := c.conn.WriteMessage(msgType, b); err != nil {
 		go c.Close()
+		return false
+	}
+	return true
+}
+
+// writeValue encodes v with this client's negotiated Codec and writes it
+// directly, bypassing the outbound queue. Used for control-plane replies
+// (pong, subscriptions) sent synchronously from the read loop.
+func (c *WSClient) writeValue(v any) bool {
+	b, msgType, err := c.codec.Encode(v)
+	if err != nil {
+		c.log.Warn("failed to encode control message", "err", err)
+		return false
 	}
+	return c.writeFrame(b, msgType)
+}
+
+// subscribe narrows the client onto symbols, dropping the wildcard the first
+// time it subscribes to anything specific. The hub-side swap is one atomic
+// Resubscribe call, not a separate Unsubscribe+Subscribe pair, so a
+// broadcast can never land in the gap while the client is registered in
+// neither set.
+func (c *WSClient) subscribe(symbols []string) {
+    if len(symbols) == 0 {
+        return
+    }
+    c.subMu.Lock()
+    delete(c.subs, wildcardSymbol)
+    for _, s := range symbols {
+        c.subs[s] = struct{}{}
+    }
+    c.subMu.Unlock()
+
+    if c.hub == nil {
+        return
+    }
+    c.hub.Resubscribe(c, []string{wildcardSymbol}, symbols)
+}
+
+func (c *WSClient) unsubscribe(symbols []string) {
+    if len(symbols) == 0 {
+        return
+    }
+    c.subMu.Lock()
+    for _, s := range symbols {
+        delete(c.subs, s)
+    }
+    c.subMu.Unlock()
+
+    if c.hub != nil {
+        c.hub.Unsubscribe(c, symbols)
+    }
+}
+
+func (c *WSClient) sendSubscriptions() {
+    c.subMu.Lock()
+    symbols := make([]string, 0, len(c.subs))
+    for s := range c.subs {
+        symbols = append(symbols, s)
+    }
+    c.subMu.Unlock()
+
+    c.writeValue(map[string]any{
+        "type":    "subscriptions",
+        "symbols": symbols,
+    })
 }
 
 func (c *WSClient) Close() {
@@ -115,13 +395,13 @@ func (c *WSClient) Run(onClose func()) {
     c.conn.SetReadLimit(maxMessageSize)
     _ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
     c.conn.SetPongHandler(func(appData string) error {
-        remote := c.conn.RemoteAddr().String()
-        log.Debug("ws pong received", "remote", remote, "appData", appData)
+        c.log.Debug("ws pong received", "appData", appData)
         return c.conn.SetReadDeadline(time.Now().Add(pongWait))
     })
     c.mu.Unlock()
 
     go c.keepAlive()
+    c.StartWriter()
 
 	defer func() {
 		c.Close()
@@ -135,44 +415,48 @@ func (c *WSClient) Run(onClose func()) {
         if err != nil {
             return
         }
-        // Handle simple application-level ping/pong
-        if msgType == websocket.TextMessage {
-            payload := strings.TrimSpace(string(data))
-            // Detect app-level ping either as raw "ping" or JSON {"type":"ping"}
-            isPing := false
-            if payload == "ping" {
-                isPing = true
-            } else {
-                var tmp struct{ Type string `json:"type"` }
-                if json.Unmarshal([]byte(payload), &tmp) == nil && strings.EqualFold(tmp.Type, "ping") {
-                    isPing = true
-                }
-            }
-            if isPing {
-                remote := c.conn.RemoteAddr().String()
-                log.Debug("app ping received", "remote", remote)
-                // Respond with a JSON pong message
-                resp := map[string]any{
-                    "type": "pong",
-                    "ts":   time.Now().UTC().Format(time.RFC3339Nano),
-                }
-                b, _ := json.Marshal(resp)
-                c.mu.Lock()
-                if c.conn != nil {
-                    _ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-                    if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
-                        c.mu.Unlock()
-                        go c.Close()
-                        return
-                    }
-                    log.Debug("app pong sent", "remote", remote)
-                }
-                c.mu.Unlock()
+        if msgType != websocket.TextMessage {
+            continue
+        }
+        payload := strings.TrimSpace(string(data))
+        if payload == "ping" {
+            c.handlePing()
+            continue
+        }
+        var ctrl controlMessage
+        if json.Unmarshal([]byte(payload), &ctrl) != nil {
+            continue
+        }
+        switch strings.ToLower(ctrl.Type) {
+        case "ping":
+            c.handlePing()
+        case "subscribe":
+            c.subscribe(ctrl.Symbols)
+        case "unsubscribe":
+            c.unsubscribe(ctrl.Symbols)
+        case "subscriptions":
+            c.sendSubscriptions()
+        case "resume":
+            if c.resume != nil {
+                c.resume(ctrl.Since)
             }
         }
     }
 }
 
+// handlePing answers an application-level {"type":"ping"} (or raw "ping")
+// message with a pong carrying the server timestamp, encoded with this
+// client's negotiated Codec.
+func (c *WSClient) handlePing() {
+    c.log.Debug("app ping received")
+    if c.writeValue(map[string]any{
+        "type": "pong",
+        "ts":   time.Now().UTC().Format(time.RFC3339Nano),
+    }) {
+        c.log.Debug("app pong sent")
+    }
+}
+
 func (c *WSClient) keepAlive() {
     ticker := time.NewTicker(pingPeriod)
     defer ticker.Stop()
@@ -197,8 +481,7 @@ func (c *WSClient) keepAlive() {
                 go c.Close()
                 return
             }
-            remote := c.conn.RemoteAddr().String()
-            log.Debug("ws ping sent", "remote", remote)
+            c.log.Debug("ws ping sent")
             c.mu.Unlock()
         case <-c.done:
             return
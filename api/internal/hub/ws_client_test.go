@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"ts_trade_ws/internal/logging"
+)
+
+// newTestClient builds a WSClient bypassing NewWSClient (which requires a
+// live *websocket.Conn), for tests that only exercise subscription
+// bookkeeping and hub routing, not the actual socket read/write loops.
+func newTestClient(h *Hub) *WSClient {
+	return &WSClient{
+		hub:      h,
+		codec:    jsonCodec{},
+		log:      logging.New(),
+		done:     make(chan struct{}),
+		subs:     map[string]struct{}{wildcardSymbol: {}},
+		outbound: make(chan wsFrame, sendQueueSize),
+	}
+}
+
+func (c *WSClient) subsSnapshot() []string {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	out := make([]string, 0, len(c.subs))
+	for s := range c.subs {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// waitForBarrier registers a fresh wildcard client, broadcasts a sentinel
+// symbol through it, and blocks until that sentinel is delivered, so the
+// test can be sure every broadcast issued beforehand has already been
+// routed by Hub.run() before it makes assertions.
+func waitForBarrier(t *testing.T, h *Hub) {
+	t.Helper()
+	barrier := &signalingClient{sentinel: "BARRIER", done: make(chan struct{})}
+	h.TryRegister(barrier, "")
+	h.Broadcast(Message{Symbol: "BARRIER"})
+	select {
+	case <-barrier.done:
+	case <-time.After(time.Second):
+		t.Fatal("barrier broadcast was never delivered")
+	}
+}
+
+func TestWSClientSubscribeNarrowsFromWildcard(t *testing.T) {
+	h := New()
+	client := newTestClient(h)
+	h.TryRegister(client, "")
+
+	client.subscribe([]string{"AAPL"})
+
+	if got := client.subsSnapshot(); len(got) != 1 || got[0] != "AAPL" {
+		t.Fatalf("subs = %v, want [AAPL]", got)
+	}
+
+	h.Broadcast(Message{Symbol: "MSFT"})
+	h.Broadcast(Message{Symbol: "AAPL"})
+	waitForBarrier(t, h)
+
+	if got := client.QueueDepth(); got != 1 {
+		t.Errorf("QueueDepth() = %d, want 1 (only the AAPL broadcast, not MSFT or the wildcard-less MSFT)", got)
+	}
+}
+
+func TestWSClientUnsubscribeRemovesRouting(t *testing.T) {
+	h := New()
+	client := newTestClient(h)
+	h.TryRegister(client, "")
+
+	client.subscribe([]string{"AAPL", "MSFT"})
+	client.unsubscribe([]string{"AAPL"})
+
+	if got := client.subsSnapshot(); len(got) != 1 || got[0] != "MSFT" {
+		t.Fatalf("subs = %v, want [MSFT]", got)
+	}
+
+	h.Broadcast(Message{Symbol: "AAPL"})
+	h.Broadcast(Message{Symbol: "MSFT"})
+	waitForBarrier(t, h)
+
+	if got := client.QueueDepth(); got != 1 {
+		t.Errorf("QueueDepth() = %d, want 1 (MSFT only, AAPL was unsubscribed)", got)
+	}
+}
+
+func TestWSClientSubscribeIsAtomicAgainstConcurrentBroadcast(t *testing.T) {
+	h := New()
+	client := newTestClient(h)
+	h.TryRegister(client, "")
+
+	// Hammer the hub with same-symbol broadcasts concurrently with the
+	// narrowing subscribe call; every single one must be routed to the
+	// client by exactly one of the wildcard or "AAPL" subscriber sets,
+	// never dropped by a window where it's registered in neither.
+	const rounds = 200
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < rounds; i++ {
+			h.Broadcast(Message{Symbol: "AAPL"})
+		}
+		close(done)
+	}()
+	client.subscribe([]string{"AAPL"})
+	<-done
+	waitForBarrier(t, h)
+
+	if got := client.QueueDepth(); got != rounds {
+		t.Errorf("QueueDepth() = %d, want %d (no broadcast dropped across the wildcard->AAPL narrowing)", got, rounds)
+	}
+}
+
+func TestWSClientSendSubscriptionsDoesNotPanicWithoutConn(t *testing.T) {
+	h := New()
+	client := newTestClient(h)
+	h.TryRegister(client, "")
+	client.subscribe([]string{"AAPL"})
+
+	// sendSubscriptions writes through writeValue/writeFrame, which must
+	// tolerate a nil conn (as in these bookkeeping-only tests) by reporting
+	// failure rather than panicking.
+	client.sendSubscriptions()
+}
+
+func TestControlMessageParsing(t *testing.T) {
+	cases := []struct {
+		payload string
+		want    controlMessage
+	}{
+		{`{"type":"subscribe","symbols":["AAPL","MSFT"]}`, controlMessage{Type: "subscribe", Symbols: []string{"AAPL", "MSFT"}}},
+		{`{"type":"unsubscribe","symbols":["AAPL"]}`, controlMessage{Type: "unsubscribe", Symbols: []string{"AAPL"}}},
+		{`{"type":"subscriptions"}`, controlMessage{Type: "subscriptions"}},
+		{`{"type":"resume","since":"2024-01-01T00:00:00Z"}`, controlMessage{Type: "resume", Since: "2024-01-01T00:00:00Z"}},
+	}
+	for _, tc := range cases {
+		var got controlMessage
+		if err := json.Unmarshal([]byte(tc.payload), &got); err != nil {
+			t.Fatalf("unmarshal %q: %v", tc.payload, err)
+		}
+		if got.Type != tc.want.Type || got.Since != tc.want.Since || len(got.Symbols) != len(tc.want.Symbols) {
+			t.Errorf("parsed %q = %+v, want %+v", tc.payload, got, tc.want)
+			continue
+		}
+		for i := range got.Symbols {
+			if got.Symbols[i] != tc.want.Symbols[i] {
+				t.Errorf("parsed %q symbols = %v, want %v", tc.payload, got.Symbols, tc.want.Symbols)
+			}
+		}
+	}
+}
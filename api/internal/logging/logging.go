@@ -0,0 +1,75 @@
+// Package logging provides the structured logger used across hub, server,
+// and postgres, plus correlation IDs for tracing a single websocket
+// connection across the hub's broadcasts and the postgres listener's
+// notifications.
+package logging
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Logger mirrors slog's call shape (msg plus alternating key/value pairs) so
+// migrating a charmbracelet/log call site is a mechanical swap.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+	// With returns a Logger that prefixes every call with kv, used to stamp
+	// a connection's correlation ID onto its whole log lifetime.
+	With(kv ...any) Logger
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// New builds the default logger, configured from the environment:
+//
+//	LOG_LEVEL  - debug, info, warn, error (default info)
+//	LOG_FORMAT - json for production log aggregation, otherwise text (default text)
+func New() Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(os.Getenv("LOG_LEVEL"))}
+	var handler slog.Handler
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("LOG_FORMAT")), "json") {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	return &slogLogger{l: slog.New(handler)}
+}
+
+func parseLevel(v string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s *slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s *slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s *slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+
+func (s *slogLogger) With(kv ...any) Logger {
+	return &slogLogger{l: s.l.With(kv...)}
+}
+
+// NewCorrelationID returns a new ULID string, stamped onto a connection's
+// logger for its whole lifetime (connect, ping/pong, broadcast delivery,
+// disconnect) so its log lines can be traced across hub and listener events.
+func NewCorrelationID() string {
+	return ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String()
+}
@@ -2,19 +2,61 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/charmbracelet/log"
 	"github.com/jackc/pgx/v5"
+
+	"ts_trade_ws/internal/logging"
+)
+
+const (
+	initialBackoff = 2 * time.Second
+	maxBackoff     = 60 * time.Second
+	catchUpLimit   = 500
+)
+
+// State describes the health of the listener's connection to Postgres, so
+// Server can log or broadcast "listener degraded" events.
+type State int
+
+const (
+	StateConnected State = iota
+	StateDegraded
+	StateReconnecting
 )
 
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
+}
+
 type Listener struct {
-	conn   *pgx.Conn
+	cfg    Config
 	ctx    context.Context
 	cancel context.CancelFunc
+	log    logging.Logger
+
+	mu       sync.Mutex
+	conn     *pgx.Conn
+	channels []string
+	lastSeen time.Time
+	repo     *Repository
+
+	state chan State
 }
 
 type Config struct {
@@ -44,9 +86,8 @@ func getenv(key, fallback string) string {
 	return fallback
 }
 
-func New(ctx context.Context) (*Listener, error) {
-	cfg := fromEnv()
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+func connString(cfg Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		cfg.User,
 		cfg.Password,
 		cfg.Host,
@@ -54,44 +95,258 @@ func New(ctx context.Context) (*Listener, error) {
 		cfg.Database,
 		cfg.SSLMode,
 	)
-	conn, err := pgx.Connect(ctx, connString)
+}
+
+func New(ctx context.Context, log logging.Logger) (*Listener, error) {
+	cfg := fromEnv()
+	conn, err := pgx.Connect(ctx, connString(cfg))
 	if err != nil {
 		return nil, err
 	}
 	log.Info("connected to postgres", "host", cfg.Host, "port", cfg.Port, "database", cfg.Database)
 	listenCtx, cancel := context.WithCancel(ctx)
-	return &Listener{conn: conn, ctx: listenCtx, cancel: cancel}, nil
+	return &Listener{
+		cfg:    cfg,
+		conn:   conn,
+		ctx:    listenCtx,
+		cancel: cancel,
+		log:    log,
+		// Seed lastSeen at connect time so a reconnect that happens before
+		// any NOTIFY ever arrives still has a valid cursor to catch up
+		// from, instead of replayMissed treating the zero value as "never
+		// run the catch-up query" and silently skipping that whole outage.
+		lastSeen: time.Now().UTC(),
+		state:    make(chan State, 8),
+	}, nil
+}
+
+// SetRepository wires a Repository used to replay predictions created while
+// the listener's connection was down. Optional: without it, reconnects skip
+// catch-up and rely solely on the resumed LISTEN stream.
+func (l *Listener) SetRepository(repo *Repository) {
+	l.mu.Lock()
+	l.repo = repo
+	l.mu.Unlock()
+}
+
+// States returns the channel Server can read listener health transitions
+// from. Sends are non-blocking, so a slow reader only misses intermediate
+// states, not the listener's ability to keep running.
+func (l *Listener) States() <-chan State {
+	return l.state
 }
 
-func (l *Listener) Listen(channel string) error {
-	identifier := pgx.Identifier{channel}
-	sql := fmt.Sprintf("LISTEN %s", identifier.Sanitize())
-	_, err := l.conn.Exec(l.ctx, sql)
-	return err
+func (l *Listener) emitState(s State) {
+	select {
+	case l.state <- s:
+	default:
+	}
+}
+
+// Listen registers one or more channels with the active connection and
+// remembers them so a reconnect can re-issue LISTEN for all of them.
+func (l *Listener) Listen(channels ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, channel := range channels {
+		identifier := pgx.Identifier{channel}
+		sql := fmt.Sprintf("LISTEN %s", identifier.Sanitize())
+		if _, err := l.conn.Exec(l.ctx, sql); err != nil {
+			return err
+		}
+		l.channels = append(l.channels, channel)
+	}
+	return nil
 }
 
-func (l *Listener) Wait(handler func(string)) error {
+// Run waits for notifications and invokes handler for each, reconnecting
+// with exponential backoff and jitter whenever the connection drops. It
+// returns nil once the listener's context is canceled; it never returns a
+// non-nil error.
+func (l *Listener) Run(handler func(string)) error {
+	backoff := initialBackoff
+	l.emitState(StateConnected)
 	for {
-		notification, err := l.conn.WaitForNotification(l.ctx)
+		notified, err := l.waitOnce(handler)
+		if err == nil {
+			return nil
+		}
+		if notified {
+			backoff = initialBackoff
+		}
+		l.emitState(StateDegraded)
+		l.log.Error("postgres listener connection lost", "err", err, "retryIn", backoff.String())
+		if !l.reconnect(&backoff, handler) {
+			return nil
+		}
+		l.emitState(StateConnected)
+	}
+}
+
+// waitOnce blocks on notifications from the current connection, reporting
+// whether at least one notification was delivered before it failed.
+func (l *Listener) waitOnce(handler func(string)) (bool, error) {
+	notified := false
+	for {
+		l.mu.Lock()
+		conn := l.conn
+		l.mu.Unlock()
+		if conn == nil {
+			return notified, errors.New("postgres: no active connection")
+		}
+		notification, err := conn.WaitForNotification(l.ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return nil
+				return notified, nil
 			}
-			return err
+			return notified, err
 		}
+		notified = true
+		l.mu.Lock()
+		l.lastSeen = time.Now().UTC()
+		l.mu.Unlock()
 		handler(notification.Payload)
 	}
 }
 
+// reconnect retries the connection with exponential backoff and full jitter
+// until it succeeds or the listener's context is canceled. While waiting it
+// periodically replays missed rows via the catch-up query so downtime
+// doesn't silently drop predictions.
+func (l *Listener) reconnect(backoff *time.Duration, handler func(string)) bool {
+	for {
+		wait := jitter(*backoff)
+		select {
+		case <-l.ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+
+		l.emitState(StateReconnecting)
+		l.replayMissed(handler)
+
+		conn, err := pgx.Connect(l.ctx, connString(l.cfg))
+		if err == nil {
+			err = l.resubscribe(conn)
+		}
+		if err != nil {
+			l.log.Error("postgres reconnect failed", "err", err, "retryIn", wait.String())
+			*backoff = nextBackoff(*backoff)
+			continue
+		}
+
+		l.mu.Lock()
+		l.conn = conn
+		channels := append([]string(nil), l.channels...)
+		l.mu.Unlock()
+		l.log.Info("postgres listener reconnected", "channels", channels)
+		l.replayMissed(handler)
+		*backoff = initialBackoff
+		return true
+	}
+}
+
+func (l *Listener) resubscribe(conn *pgx.Conn) error {
+	l.mu.Lock()
+	channels := append([]string(nil), l.channels...)
+	l.mu.Unlock()
+	for _, channel := range channels {
+		identifier := pgx.Identifier{channel}
+		sql := fmt.Sprintf("LISTEN %s", identifier.Sanitize())
+		if _, err := conn.Exec(l.ctx, sql); err != nil {
+			_ = conn.Close(l.ctx)
+			return err
+		}
+	}
+	return nil
+}
+
+// replayMissed queries predictions created since the last notification we
+// saw and feeds them through handler, re-marshaled to match the NOTIFY
+// payload shape. It pages through the full backlog with the advancing
+// cursor rather than a single bounded fetch, so an outage producing more
+// than catchUpLimit rows is still fully replayed. It is a no-op until
+// SetRepository has been called.
+func (l *Listener) replayMissed(handler func(string)) {
+	l.mu.Lock()
+	repo := l.repo
+	since := l.lastSeen
+	l.mu.Unlock()
+	if repo == nil || since.IsZero() {
+		return
+	}
+
+	cursor := since
+	total := 0
+	for {
+		rows, err := repo.GetPredictionsSince(l.ctx, cursor, catchUpLimit)
+		if err != nil {
+			l.log.Error("postgres catch-up query failed", "err", err)
+			break
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, p := range rows {
+			b, err := json.Marshal(p)
+			if err != nil {
+				continue
+			}
+			handler(string(b))
+			if p.CreatedAt.After(cursor) {
+				cursor = p.CreatedAt
+			}
+		}
+		total += len(rows)
+
+		l.mu.Lock()
+		l.lastSeen = cursor
+		l.mu.Unlock()
+
+		if len(rows) < catchUpLimit {
+			break
+		}
+	}
+	if total > 0 {
+		l.log.Info("replayed missed predictions", "count", total)
+	}
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > maxBackoff {
+		next = maxBackoff
+	}
+	return next
+}
+
+// jitter applies full jitter in [d/2, d) so reconnecting clients don't retry
+// in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	half := d / 2
+	n := rand.Int63n(int64(d - half))
+	return half + time.Duration(n)
+}
+
 func (l *Listener) Close() error {
 	l.cancel()
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	err := l.conn.Close(ctx)
+	err := conn.Close(ctx)
 	if err != nil {
-		log.Error("failed to close postgres connection", "err", err)
+		l.log.Error("failed to close postgres connection", "err", err)
 		return err
 	}
-	log.Info("postgres listener connection closed")
+	l.log.Info("postgres listener connection closed")
 	return nil
 }
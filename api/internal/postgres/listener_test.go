@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoffDoublesAndCaps(t *testing.T) {
+	cases := []struct {
+		in   time.Duration
+		want time.Duration
+	}{
+		{initialBackoff, 4 * time.Second},
+		{30 * time.Second, 60 * time.Second},
+		{maxBackoff, maxBackoff},
+		{45 * time.Second, maxBackoff},
+	}
+	for _, tc := range cases {
+		if got := nextBackoff(tc.in); got != tc.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestJitterWithinHalfOpenRange(t *testing.T) {
+	d := 10 * time.Second
+	for i := 0; i < 100; i++ {
+		got := jitter(d)
+		if got < d/2 || got >= d {
+			t.Fatalf("jitter(%s) = %s, want within [%s, %s)", d, got, d/2, d)
+		}
+	}
+}
+
+func TestJitterNonPositiveIsNoop(t *testing.T) {
+	if got := jitter(0); got != 0 {
+		t.Errorf("jitter(0) = %s, want 0", got)
+	}
+	if got := jitter(-time.Second); got != -time.Second {
+		t.Errorf("jitter(-1s) = %s, want -1s", got)
+	}
+}
@@ -5,9 +5,10 @@ import (
     "fmt"
     "time"
 
-    "github.com/charmbracelet/log"
     "github.com/jackc/pgx/v5/pgxpool"
     "github.com/jackc/pgx/v5/pgtype"
+
+    "ts_trade_ws/internal/logging"
 )
 
 // Repository provides read access to recent predictions.
@@ -34,7 +35,7 @@ type Prediction struct {
 }
 
 // NewRepository initialises a connection pool for read queries using env config.
-func NewRepository(ctx context.Context) (*Repository, error) {
+func NewRepository(ctx context.Context, log logging.Logger) (*Repository, error) {
     cfg := fromEnv()
     connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
         cfg.User,
@@ -135,3 +136,81 @@ func (r *Repository) GetRecentPredictions(ctx context.Context, limit int) ([]Pre
     return out, nil
 }
 
+// GetPredictionsSince returns predictions created strictly after since,
+// ordered oldest-first, capped at limit. It backs both the listener's
+// catch-up query after a reconnect and a client's `?since=` resume request.
+func (r *Repository) GetPredictionsSince(ctx context.Context, since time.Time, limit int) ([]Prediction, error) {
+    if limit <= 0 {
+        limit = 100
+    }
+    const q = `
+        SELECT
+            symbol,
+            probability,
+            exposure,
+            forecast_volatility,
+            bars_count,
+            trained_samples,
+            window_rows,
+            window_cols,
+            created_at
+        FROM predictions
+        WHERE created_at > $1
+        ORDER BY created_at ASC
+        LIMIT $2
+    `
+    rows, err := r.pool.Query(ctx, q, since, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    out := make([]Prediction, 0, limit)
+    for rows.Next() {
+        var (
+            symbol string
+            probability float64
+            exposure float64
+            forecastVol float64
+            barsCount int32
+            trainedSamples int32
+            windowRows pgtype.Int4
+            windowCols pgtype.Int4
+            createdAt time.Time
+        )
+        if err := rows.Scan(
+            &symbol,
+            &probability,
+            &exposure,
+            &forecastVol,
+            &barsCount,
+            &trainedSamples,
+            &windowRows,
+            &windowCols,
+            &createdAt,
+        ); err != nil {
+            return nil, err
+        }
+        var ws *WindowShape
+        if windowRows.Valid && windowCols.Valid {
+            ws = &WindowShape{Rows: windowRows.Int32, Cols: windowCols.Int32}
+        } else {
+            ws = nil
+        }
+        out = append(out, Prediction{
+            Symbol:             symbol,
+            Probability:        probability,
+            Exposure:           exposure,
+            ForecastVolatility: forecastVol,
+            BarsCount:          barsCount,
+            TrainedSamples:     trainedSamples,
+            WindowShape:        ws,
+            CreatedAt:          createdAt,
+        })
+    }
+    if rows.Err() != nil {
+        return nil, rows.Err()
+    }
+    return out, nil
+}
+
@@ -0,0 +1,122 @@
+// Package realip resolves the true client IP for a request proxied through
+// nginx/Caddy/Apache/a load balancer, so logging and per-IP connection
+// limits aren't keyed on the proxy's own address.
+package realip
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// trustedProxies is read once from TRUSTED_PROXIES, a comma-separated list
+// of CIDRs (e.g. "10.0.0.0/8,192.168.0.0/16"). Only a request whose
+// immediate peer (r.RemoteAddr) falls in one of these ranges has its
+// forwarding headers honored; otherwise they're attacker-controlled and
+// ignored.
+var trustedProxies []*net.IPNet
+
+func init() {
+	trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+}
+
+func parseTrustedProxies(v string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(v, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the resolved client IP for r: if r.RemoteAddr is a
+// trusted proxy, it's read from the Forwarded, X-Forwarded-For, or
+// X-Real-IP headers (in that order of precedence); otherwise r.RemoteAddr
+// itself is returned, since an untrusted peer's headers can't be believed.
+func FromRequest(r *http.Request) string {
+	peer := remoteIP(r.RemoteAddr)
+	if peer == nil || !isTrusted(peer) {
+		return hostOrRaw(r.RemoteAddr)
+	}
+	if ip := fromForwarded(r.Header.Get("Forwarded")); ip != "" {
+		return ip
+	}
+	if ip := fromXForwardedFor(r.Header.Get("X-Forwarded-For")); ip != "" {
+		return ip
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return hostOrRaw(r.RemoteAddr)
+}
+
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func hostOrRaw(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// fromXForwardedFor returns the left-most (original client) address in a
+// X-Forwarded-For chain. Only the immediate peer has been checked against
+// trustedProxies; the addresses listed in the header are not checked.
+func fromXForwardedFor(v string) string {
+	parts := strings.Split(v, ",")
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(parts[0])
+}
+
+// fromForwarded extracts the first "for=" parameter from an RFC 7239
+// Forwarded header, stripping IPv6 brackets and any port suffix.
+func fromForwarded(v string) string {
+	for _, part := range strings.Split(v, ",") {
+		for _, pair := range strings.Split(part, ";") {
+			pair = strings.TrimSpace(pair)
+			k, val, ok := strings.Cut(pair, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(k), "for") {
+				continue
+			}
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			val = strings.TrimPrefix(val, "[")
+			if i := strings.Index(val, "]"); i != -1 {
+				return val[:i]
+			}
+			if host, _, err := net.SplitHostPort(val); err == nil {
+				return host
+			}
+			return val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package realip
+
+import (
+	"net/http"
+	"testing"
+)
+
+// withTrustedProxies swaps the package-level trustedProxies for the duration
+// of a test, restoring the previous value afterward, since init() only ever
+// parses TRUSTED_PROXIES once at package load.
+func withTrustedProxies(t *testing.T, cidrs string) {
+	t.Helper()
+	prev := trustedProxies
+	trustedProxies = parseTrustedProxies(cidrs)
+	t.Cleanup(func() { trustedProxies = prev })
+}
+
+func newRequest(remoteAddr string, headers map[string]string) *http.Request {
+	r := &http.Request{RemoteAddr: remoteAddr, Header: http.Header{}}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestFromRequestUntrustedPeerIgnoresHeaders(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	r := newRequest("203.0.113.5:5678", map[string]string{
+		"X-Forwarded-For": "198.51.100.9",
+	})
+	if got := FromRequest(r); got != "203.0.113.5" {
+		t.Errorf("FromRequest = %q, want raw peer for an untrusted proxy", got)
+	}
+}
+
+func TestFromRequestTrustedPeerHonorsXForwardedFor(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	r := newRequest("10.1.2.3:5678", map[string]string{
+		"X-Forwarded-For": "198.51.100.9, 10.1.2.3",
+	})
+	if got := FromRequest(r); got != "198.51.100.9" {
+		t.Errorf("FromRequest = %q, want left-most X-Forwarded-For address", got)
+	}
+}
+
+func TestFromRequestPrefersForwardedOverXForwardedFor(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	r := newRequest("10.1.2.3:5678", map[string]string{
+		"Forwarded":       `for="[2001:db8::1]:443"`,
+		"X-Forwarded-For": "198.51.100.9",
+	})
+	if got := FromRequest(r); got != "2001:db8::1" {
+		t.Errorf("FromRequest = %q, want Forwarded to take precedence", got)
+	}
+}
+
+func TestFromRequestFallsBackToXRealIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	r := newRequest("10.1.2.3:5678", map[string]string{
+		"X-Real-IP": "198.51.100.9",
+	})
+	if got := FromRequest(r); got != "198.51.100.9" {
+		t.Errorf("FromRequest = %q, want X-Real-IP", got)
+	}
+}
+
+func TestFromRequestNoHeadersUsesPeer(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	r := newRequest("10.1.2.3:5678", nil)
+	if got := FromRequest(r); got != "10.1.2.3" {
+		t.Errorf("FromRequest = %q, want raw peer when no forwarding headers are set", got)
+	}
+}
+
+func TestParseTrustedProxiesSkipsInvalidEntries(t *testing.T) {
+	nets := parseTrustedProxies("10.0.0.0/8, not-a-cidr ,192.168.0.0/16,")
+	if len(nets) != 2 {
+		t.Fatalf("parseTrustedProxies returned %d nets, want 2", len(nets))
+	}
+}
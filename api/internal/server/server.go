@@ -6,12 +6,14 @@ import (
     "net/http"
     "strconv"
     "sync"
+    "time"
 
-    "github.com/charmbracelet/log"
     "github.com/gorilla/websocket"
 
     "ts_trade_ws/internal/hub"
+    "ts_trade_ws/internal/logging"
     "ts_trade_ws/internal/postgres"
+    "ts_trade_ws/internal/realip"
 )
 
 type Server struct {
@@ -19,14 +21,15 @@ type Server struct {
     hub      *hub.Hub
     listener *postgres.Listener
     repo     *postgres.Repository
+    log      logging.Logger
     ctx      context.Context
     cancel   context.CancelFunc
     wg       sync.WaitGroup
 }
 
-func New(ctx context.Context) (*Server, error) {
+func New(ctx context.Context, log logging.Logger) (*Server, error) {
     h := hub.New()
-    listener, err := postgres.New(ctx)
+    listener, err := postgres.New(ctx, log)
     if err != nil {
         return nil, err
     }
@@ -34,51 +37,213 @@ func New(ctx context.Context) (*Server, error) {
         listener.Close()
         return nil, err
     }
-    repo, err := postgres.NewRepository(ctx)
+    repo, err := postgres.NewRepository(ctx, log)
     if err != nil {
         listener.Close()
         return nil, err
     }
+    listener.SetRepository(repo)
     ctx, cancel := context.WithCancel(ctx)
     s := &Server{
         hub:      h,
         listener: listener,
         repo:     repo,
+        log:      log,
         ctx:      ctx,
         cancel:   cancel,
         upgrader: websocket.Upgrader{
-            CheckOrigin: func(r *http.Request) bool { return true },
+            CheckOrigin:       func(r *http.Request) bool { return true },
+            Subprotocols:      hub.Subprotocols,
+            EnableCompression: true,
         },
     }
+	s.wg.Add(1)
+	go s.watchListenerHealth()
 	s.wg.Add(1)
 	go s.listenForNotifications()
 	log.Info("websocket server initialised", "channel", "predictions")
 	return s, nil
 }
 
+// watchListenerHealth logs transitions reported by the postgres listener so
+// operators can see "listener degraded" / reconnected events.
+func (s *Server) watchListenerHealth() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case state, ok := <-s.listener.States():
+			if !ok {
+				return
+			}
+			switch state {
+			case postgres.StateConnected:
+				s.log.Info("postgres listener healthy", "state", state.String())
+			default:
+				s.log.Warn("postgres listener degraded", "state", state.String())
+			}
+		}
+	}
+}
+
 func (s *Server) listenForNotifications() {
 	defer s.wg.Done()
-	err := s.listener.Wait(func(payload string) {
-		s.hub.Broadcast(payload)
-		log.Debug("broadcast payload", "length", len(payload))
+	err := s.listener.Run(func(payload string) {
+		var pred postgres.Prediction
+		if err := json.Unmarshal([]byte(payload), &pred); err != nil {
+			s.log.Warn("dropping malformed notification payload", "err", err)
+			return
+		}
+		msg := hub.Message{
+			Symbol:    pred.Symbol,
+			Data:      pred,
+			Timestamp: time.Now().UTC(),
+		}
+		s.hub.Broadcast(msg)
+		s.log.Debug("broadcast payload", "symbol", msg.Symbol)
 	})
 	if err != nil {
-		log.Error("postgres listener stopped", "err", err)
+		s.log.Error("postgres listener stopped", "err", err)
 	}
 }
 
 func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
+	ip := realip.FromRequest(r)
+	if s.hub.AtIPLimit(ip) {
+		http.Error(w, "too many connections from this address", http.StatusTooManyRequests)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Error("websocket upgrade failed", "err", err)
+		s.log.Error("websocket upgrade failed", "err", err)
+		return
+	}
+	client := hub.NewWSClient(conn, s.hub, ip, conn.Subprotocol(), s.log)
+	client.SetResumeHandler(func(since string) {
+		s.replaySince(client, since)
+	})
+
+	if v := r.URL.Query().Get("last_id"); v != "" {
+		client.Log().Warn("?last_id resume cursor is not supported (predictions has no id column); use ?since= instead")
+	}
+	var joined bool
+	if since, ok := s.parseSinceCursor(client, r.URL.Query().Get("since")); ok {
+		joined = s.resumeAndJoin(client, ip, since)
+	} else {
+		joined = s.hub.TryRegister(client, ip)
+	}
+	if !joined {
+		client.Log().Warn("rejecting connection, IP is at its connection limit")
+		client.Close()
 		return
 	}
-	client := hub.NewWSClient(conn)
-	s.hub.Register(client)
-	log.Info("client connected", "remote", r.RemoteAddr)
+
+	client.Log().Info("client connected")
 	go client.Run(func() {
 		s.hub.Unregister(client)
-		log.Info("client disconnected", "remote", r.RemoteAddr)
+		client.Log().Info("client disconnected")
+	})
+}
+
+// resumeBacklogLimit caps how many rows a single resume request replays.
+const resumeBacklogLimit = 500
+
+// parseSinceCursor parses an RFC3339 ?since= value, logging a warning under
+// client's correlation ID if it's present but malformed.
+func (s *Server) parseSinceCursor(client *hub.WSClient, v string) (time.Time, bool) {
+    if v == "" {
+        return time.Time{}, false
+    }
+    t, err := time.Parse(time.RFC3339, v)
+    if err != nil {
+        client.Log().Warn("invalid since cursor", "value", v, "err", err)
+        return time.Time{}, false
+    }
+    return t, true
+}
+
+// resumeAndJoin backfills predictions created after since, then joins the
+// client to the hub atomically with a replay of anything broadcast while
+// the backfill query was running, so nothing is delivered twice or skipped.
+// It returns false, without sending anything, if ip is at its connection
+// limit.
+func (s *Server) resumeAndJoin(client *hub.WSClient, ip string, since time.Time) bool {
+	// Start draining the outbound queue before any backfill row is sent, so
+	// a backlog larger than the queue's buffer can't silently drop frames
+	// nobody is reading yet (Run's writeLoop doesn't start until later).
+	client.StartWriter()
+	cursor := s.replayBacklog(client, since)
+	// Capture the bridge cutoff only after the backfill query returns, so
+	// the ring-buffer bridge below covers just the join gap rather than the
+	// whole backfill window; otherwise a broadcast during the query would
+	// satisfy both the backfill and the bridge and be delivered twice.
+	cutoff := time.Now().UTC()
+	bridge, ok := s.hub.TryJoin(client, ip, cutoff)
+	if !ok {
+		return false
+	}
+	for _, msg := range bridge {
+		client.Send(msg)
+	}
+	if len(bridge) > 0 {
+		cursor = bridge[len(bridge)-1].Timestamp
+	}
+	sendResumeComplete(client, cursor)
+	return true
+}
+
+// replaySince handles an in-band {"type":"resume"} request from a client
+// that is already registered and receiving live broadcasts, so it only
+// needs the historical backfill, not the join-time bridge.
+func (s *Server) replaySince(client *hub.WSClient, sinceRaw string) {
+	since, ok := s.parseSinceCursor(client, sinceRaw)
+	if !ok {
+		return
+	}
+	cursor := s.replayBacklog(client, since)
+	sendResumeComplete(client, cursor)
+}
+
+// replayBacklog streams every prediction created after since to client,
+// paging with the advancing cursor until a page returns fewer than
+// resumeBacklogLimit rows, and returns the cursor (latest created_at seen,
+// or since if there were none). Callers must not tell the client it's fully
+// caught up until this returns, since a single bounded fetch would silently
+// drop anything past the first page.
+func (s *Server) replayBacklog(client *hub.WSClient, since time.Time) time.Time {
+	cursor := since
+	for {
+		rows, err := s.repo.GetPredictionsSince(s.ctx, cursor, resumeBacklogLimit)
+		if err != nil {
+			client.Log().Error("resume backfill query failed", "err", err)
+			return cursor
+		}
+		if len(rows) == 0 {
+			return cursor
+		}
+		for _, p := range rows {
+			client.Send(hub.Message{Symbol: p.Symbol, Data: p, Timestamp: p.CreatedAt})
+			if p.CreatedAt.After(cursor) {
+				cursor = p.CreatedAt
+			}
+		}
+		if len(rows) < resumeBacklogLimit {
+			return cursor
+		}
+	}
+}
+
+// sendResumeComplete tells the client live mode has begun and what cursor
+// to use if it needs to resume again later.
+func sendResumeComplete(client *hub.WSClient, cursor time.Time) {
+	client.Send(hub.Message{
+		Data: map[string]any{
+			"type":   "resume_complete",
+			"cursor": cursor.UTC().Format(time.RFC3339Nano),
+		},
+		Timestamp: cursor,
 	})
 }
 
@@ -90,7 +255,17 @@ func (s *Server) Close() {
     }
     s.hub.Shutdown(context.Background())
     s.wg.Wait()
-    log.Info("websocket server shut down")
+    s.log.Info("websocket server shut down")
+}
+
+// HandleHubMetrics writes hub backpressure counters (dropped messages,
+// evictions, per-client queue depth) as JSON, so operators can see slow
+// consumers without shelling into the box.
+func (s *Server) HandleHubMetrics(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(s.hub.Metrics()); err != nil {
+        s.log.Error("failed to encode hub metrics", "err", err)
+    }
 }
 
 // HandleRecent writes the most recent predictions as JSON.
@@ -104,7 +279,7 @@ func (s *Server) HandleRecent(w http.ResponseWriter, r *http.Request) {
     }
     preds, err := s.repo.GetRecentPredictions(r.Context(), limit)
     if err != nil {
-        log.Error("failed to fetch recent predictions", "err", err)
+        s.log.Error("failed to fetch recent predictions", "err", err)
         http.Error(w, "failed to fetch predictions", http.StatusInternalServerError)
         return
     }
@@ -113,6 +288,6 @@ func (s *Server) HandleRecent(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Access-Control-Allow-Origin", "*")
     enc := json.NewEncoder(w)
     if err := enc.Encode(preds); err != nil {
-        log.Error("failed to encode predictions", "err", err)
+        s.log.Error("failed to encode predictions", "err", err)
     }
 }